@@ -0,0 +1,48 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2022 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+package usb
+
+// EndpointType identifies the USB transfer type an endpoint speaks. The
+// numeric values line up with libusb's LIBUSB_TRANSFER_TYPE_* constants so
+// descriptors can be converted without a translation table.
+type EndpointType uint8
+
+const (
+	EndpointTypeControl EndpointType = iota
+	// EndpointTypeIsochronous is reported for isochronous endpoints so
+	// DeviceInfo.Endpoints stays a complete descriptor dump, but RawDevice
+	// has no isochronous transfer path yet: libusb has no synchronous
+	// libusb_*_transfer call for it, so it needs its own packet-framed async
+	// submission, not a Transfer dispatch branch. Transfer rejects it with
+	// an error instead of silently misusing libusb_bulk_transfer.
+	EndpointTypeIsochronous
+	EndpointTypeBulk
+	EndpointTypeInterrupt
+)
+
+// EndpointInfo describes a single endpoint exposed by the interface
+// altsetting a DeviceInfo was enumerated from.
+type EndpointInfo struct {
+	Address       uint8        // bEndpointAddress, direction encoded in the high bit
+	Type          EndpointType // Transfer type the endpoint speaks
+	MaxPacketSize uint16       // wMaxPacketSize
+	Interval      uint8        // bInterval, meaningful for interrupt/isochronous endpoints
+}
+
+// In reports whether the endpoint is a device-to-host (IN) endpoint.
+func (e EndpointInfo) In() bool {
+	return e.Address&0x80 != 0
+}
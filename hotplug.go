@@ -0,0 +1,82 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2022 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+package usb
+
+// HotplugEventKind enumerates the device lifecycle transitions that Watch
+// can be asked to report, and may be OR-ed together.
+type HotplugEventKind uint8
+
+const (
+	// HotplugArrived fires when a matching device is plugged in.
+	HotplugArrived HotplugEventKind = 1 << iota
+	// HotplugLeft fires when a matching device is unplugged.
+	HotplugLeft
+
+	// HotplugAny subscribes to both arrival and removal events.
+	HotplugAny = HotplugArrived | HotplugLeft
+)
+
+// HotplugEvent is delivered on the channel returned by Watch whenever a
+// device matching the subscription arrives or leaves. Arrivals, and
+// departures found by polling, carry the enumerated DeviceInfo; a departure
+// reported by libusb's native hotplug support only sets Info.VendorID and
+// Info.ProductID.
+type HotplugEvent struct {
+	Info DeviceInfo       // The device the event is about, see above for what a departure carries
+	Kind HotplugEventKind // Whether the device arrived or left
+}
+
+// hotplugBacklog caps the number of buffered, unconsumed events kept per
+// Watch subscription before the oldest one is dropped to make room for the
+// newest. This bounds memory use for subscribers that fall behind instead of
+// ever blocking libusb's event thread.
+const hotplugBacklog = 64
+
+// Watch subscribes to hotplug notifications for devices matching vendorID
+// and productID, filtered to the requested event kinds:
+//   - If the vendor id is set to 0 then any vendor matches.
+//   - If the product id is set to 0 then any product matches.
+//
+// It returns a channel of events, a function to stop the subscription and
+// release its resources, and an error if the subscription could not be
+// established. The returned channel is closed after the stop function has
+// been called and its background goroutine has exited.
+//
+// Where the platform and libusb build support it (libusb_has_capability
+// reports LIBUSB_CAP_HAS_HOTPLUG) events are delivered natively off
+// libusb's hotplug callback; everywhere else, notably Windows, Watch falls
+// back to periodically diffing raw device enumerations (enumerateRaw; hidapi
+// devices are not included). Slow consumers drop the
+// oldest buffered event rather than stalling the watcher.
+func Watch(events HotplugEventKind, vendorID, productID uint16) (<-chan HotplugEvent, func(), error) {
+	return watchRaw(events, vendorID, productID)
+}
+
+// dropOldestSend delivers event on sink without ever blocking: if the
+// channel is full, the oldest queued event is discarded to make room.
+func dropOldestSend(sink chan HotplugEvent, event HotplugEvent) {
+	for {
+		select {
+		case sink <- event:
+			return
+		default:
+		}
+		select {
+		case <-sink:
+		default:
+		}
+	}
+}
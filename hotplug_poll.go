@@ -0,0 +1,39 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2026 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+package usb
+
+// diffHotplugSnapshot compares a freshly enumerated snapshot against the
+// previously seen one, keyed by DeviceInfo.Path, and reports which devices
+// newly arrived or left since. It has no libusb dependency, unlike the
+// enumeration that produces infos, so watchRawPolling's diffing can be
+// exercised directly in tests.
+func diffHotplugSnapshot(seen map[string]DeviceInfo, infos []DeviceInfo, events HotplugEventKind) (current map[string]DeviceInfo, arrived, left []DeviceInfo) {
+	current = make(map[string]DeviceInfo, len(infos))
+	for _, info := range infos {
+		current[info.Path] = info
+		if _, ok := seen[info.Path]; !ok && events&HotplugArrived != 0 {
+			arrived = append(arrived, info)
+		}
+	}
+	if events&HotplugLeft != 0 {
+		for path, info := range seen {
+			if _, ok := current[path]; !ok {
+				left = append(left, info)
+			}
+		}
+	}
+	return current, arrived, left
+}
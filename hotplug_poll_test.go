@@ -0,0 +1,49 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2026 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+package usb
+
+import "testing"
+
+// Tests that diffHotplugSnapshot reports arrivals and departures keyed by
+// Path, and honors the requested event kinds.
+func TestDiffHotplugSnapshot(t *testing.T) {
+	a := DeviceInfo{Path: "1:2:0:0"}
+	b := DeviceInfo{Path: "1:2:0:1"}
+	c := DeviceInfo{Path: "3:4:0:0"}
+
+	seen := map[string]DeviceInfo{a.Path: a, b.Path: b}
+
+	current, arrived, left := diffHotplugSnapshot(seen, []DeviceInfo{a, c}, HotplugAny)
+	if len(arrived) != 1 || arrived[0].Path != c.Path {
+		t.Fatalf("arrived = %+v, want just %v", arrived, c)
+	}
+	if len(left) != 1 || left[0].Path != b.Path {
+		t.Fatalf("left = %+v, want just %v", left, b)
+	}
+	if len(current) != 2 || current[a.Path].Path != a.Path || current[c.Path].Path != c.Path {
+		t.Fatalf("current = %+v, want {%s, %s}", current, a.Path, c.Path)
+	}
+
+	// A disabled event kind must not be reported, even though the
+	// underlying snapshot still changed.
+	_, arrived, left = diffHotplugSnapshot(seen, []DeviceInfo{a, c}, HotplugArrived)
+	if len(arrived) != 1 {
+		t.Fatalf("arrived = %+v, want just %v", arrived, c)
+	}
+	if len(left) != 0 {
+		t.Fatalf("left = %+v, want none with HotplugLeft unset", left)
+	}
+}
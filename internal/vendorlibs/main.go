@@ -0,0 +1,329 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2023 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+// Command vendorlibs re-vendors the upstream hidapi and libusb sources that
+// this library's cgo backends build against. It is driven entirely by
+// libs.json at the repository root, which pins an exact {repo, ref, sha256}
+// per library, so re-running it is reproducible instead of grabbing
+// whatever happens to be at HEAD.
+//
+// Unlike the updater/update_libraries.go script it replaces, it verifies the
+// cloned tree against the manifest before touching anything, re-applies the
+// local patch series in patches/<lib>/*.patch afterwards so in-tree fixes
+// survive the update, and fails loudly instead of limping on with a
+// half-updated tree. Invoke it via `go generate ./...` from the repository
+// root, or directly as `go run ./internal/vendorlibs`.
+//
+// Bootstrapping a library that has no pinned sha256 yet (an empty string in
+// libs.json) is a deliberate two-step process: a first run clones the ref,
+// prints the tree hash it computed, then discards the clone, restores the
+// previous tree and fails, so the operator can audit that ref and paste the
+// hash into libs.json; every run after that verifies against it like any
+// other entry.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestPath is the pinned-version manifest this tool reads, relative to
+// the repository root.
+const manifestPath = "libs.json"
+
+// dummyGoTemplate is written into every vendored directory so that
+// `go mod vendor` has something Go-shaped to pick up; see updateLibusb in
+// the old updater for the convention this preserves.
+const dummyGoTemplate = `// +build dummy
+
+// This Go file is part of a workaround for "go mod vendor".
+package %s
+`
+
+// libManifest is the pinned version of a single vendored library. SHA256 may
+// be empty to bootstrap a library that has never been vendored before; see
+// the package doc.
+type libManifest struct {
+	Repo   string `json:"repo"`
+	Ref    string `json:"ref"`
+	SHA256 string `json:"sha256"`
+}
+
+// libVersion is what gets written to <lib>_version.json after a successful
+// update, so the currently vendored version is machine readable without
+// re-cloning anything.
+type libVersion struct {
+	Commit      string `json:"commit"`
+	Tag         string `json:"tag"`
+	UpstreamURL string `json:"upstream_url"`
+	SHA256      string `json:"sha256"`
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "vendorlibs: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	raw, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", manifestPath, err)
+	}
+	var manifest map[string]libManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return fmt.Errorf("parsing %s: %w", manifestPath, err)
+	}
+
+	// Iterate in a stable order so failures are reproducible to read about.
+	names := make([]string, 0, len(manifest))
+	for name := range manifest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := updateLib(name, manifest[name]); err != nil {
+			return fmt.Errorf("updating %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// updateLib shallow-clones lib at its pinned ref, verifies it against the
+// manifest, re-applies the local patch series and regenerates the dummy.go
+// stubs, leaving the previous tree in place if anything goes wrong.
+func updateLib(name string, lib libManifest) error {
+	dir := filepath.Clean(name)
+	oldDir := dir + "_old"
+
+	if err := os.RemoveAll(oldDir); err != nil {
+		return fmt.Errorf("removing stale %s: %w", oldDir, err)
+	}
+	if _, err := os.Stat(dir); err == nil {
+		if err := os.Rename(dir, oldDir); err != nil {
+			return fmt.Errorf("stashing current %s: %w", dir, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("stat %s: %w", dir, err)
+	}
+
+	if err := shallowClone(lib.Repo, lib.Ref, dir); err != nil {
+		return restoreAndFail(dir, oldDir, fmt.Errorf("cloning %s@%s: %w", lib.Repo, lib.Ref, err))
+	}
+
+	commit, err := gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return restoreAndFail(dir, oldDir, fmt.Errorf("reading clone commit: %w", err))
+	}
+
+	sum, err := treeSHA256(dir)
+	if err != nil {
+		return restoreAndFail(dir, oldDir, fmt.Errorf("hashing tree: %w", err))
+	}
+	if lib.SHA256 == "" {
+		// Bootstrap: libs.json doesn't pin a hash for this library yet. Print
+		// the hash this clone produced, then discard it and put the previous
+		// tree back without applying patches, so the operator can audit the
+		// ref and copy the printed sha256 into libs.json before running
+		// again - from then on every update is verified against it like any
+		// other entry.
+		fmt.Fprintf(os.Stderr, "vendorlibs: %s has no pinned sha256 yet; discarding this clone of %s@%s\n", name, lib.Repo, lib.Ref)
+		fmt.Fprintf(os.Stderr, "vendorlibs: pin it by setting libs.json %q sha256 to %s, then re-run\n", name, sum)
+		return restoreAndFail(dir, oldDir, fmt.Errorf("%s: no pinned sha256 to verify against, see above", name))
+	}
+	if sum != lib.SHA256 {
+		return restoreAndFail(dir, oldDir, fmt.Errorf("tree sha256 mismatch: manifest says %s, got %s", lib.SHA256, sum))
+	}
+
+	if err := applyPatches(name, dir); err != nil {
+		return restoreAndFail(dir, oldDir, fmt.Errorf("applying patches: %w", err))
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, ".git")); err != nil {
+		return restoreAndFail(dir, oldDir, fmt.Errorf("stripping .git metadata: %w", err))
+	}
+	if err := writeDummyStubs(name, dir); err != nil {
+		return restoreAndFail(dir, oldDir, fmt.Errorf("writing dummy.go stubs: %w", err))
+	}
+
+	version := libVersion{
+		Commit:      commit,
+		Tag:         lib.Ref,
+		UpstreamURL: lib.Repo,
+		SHA256:      sum,
+	}
+	out, err := json.MarshalIndent(version, "", "  ")
+	if err != nil {
+		return restoreAndFail(dir, oldDir, fmt.Errorf("encoding %s_version.json: %w", name, err))
+	}
+	if err := os.WriteFile(name+"_version.json", append(out, '\n'), 0644); err != nil {
+		return restoreAndFail(dir, oldDir, fmt.Errorf("writing %s_version.json: %w", name, err))
+	}
+
+	return os.RemoveAll(oldDir)
+}
+
+// restoreAndFail puts the previous tree back in place of a failed update so
+// a bad run never leaves the repository half-updated, then returns cause.
+func restoreAndFail(dir, oldDir string, cause error) error {
+	os.RemoveAll(dir)
+	if _, err := os.Stat(oldDir); err == nil {
+		os.Rename(oldDir, dir)
+	}
+	return cause
+}
+
+// shallowClone fetches exactly ref (a tag, branch or commit) from repo into
+// dir. A tag or branch is cloned at depth 1, without the rest of history; a
+// bare commit is not shallow: it falls back to a full clone followed by a
+// detached checkout of ref.
+func shallowClone(repo, ref, dir string) error {
+	cmd := exec.Command("git", "clone", "--depth", "1", "--branch", ref, repo, dir)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err == nil {
+		return nil
+	}
+	// ref may be a bare commit, which --branch cannot fetch directly.
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := runIn("", "git", "clone", repo, dir); err != nil {
+		return err
+	}
+	return runIn(dir, "git", "checkout", "--detach", ref)
+}
+
+// treeSHA256 hashes the checked-out working tree (excluding .git) so it can
+// be compared against the manifest's pinned sha256, independent of the
+// specific clone transport or shallow-clone history libusb/hidapi commit.
+func treeSHA256(dir string) (string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(rel))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// applyPatches re-applies every patches/<lib>/*.patch on top of the freshly
+// cloned tree, in name order, so local fixes survive an upstream update.
+func applyPatches(name, dir string) error {
+	patchDir := filepath.Join("patches", name)
+	entries, err := os.ReadDir(patchDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var patches []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".patch") {
+			patches = append(patches, entry.Name())
+		}
+	}
+	sort.Strings(patches)
+
+	for _, patch := range patches {
+		path, err := filepath.Abs(filepath.Join(patchDir, patch))
+		if err != nil {
+			return err
+		}
+		if err := runIn(dir, "git", "apply", path); err != nil {
+			return fmt.Errorf("%s: %w", patch, err)
+		}
+	}
+	return nil
+}
+
+// writeDummyStubs walks dir and drops a dummy.go into every non-test,
+// non-hidden subdirectory, matching the package name of its containing
+// folder, so `go mod vendor` has a buildable (if inert) Go file to vendor.
+func writeDummyStubs(name, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if strings.Contains(path, "test") {
+			return filepath.SkipDir
+		}
+		pkg := d.Name()
+		if path == dir {
+			pkg = name
+		}
+		return os.WriteFile(filepath.Join(path, "dummy.go"), []byte(fmt.Sprintf(dummyGoTemplate, pkg)), 0644)
+	})
+}
+
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func runIn(dir string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,111 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2023 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build android,cgo
+
+package usb
+
+// #include "./libusb/libusb/libusb.h"
+import "C"
+
+import (
+	"fmt"
+	"sync"
+)
+
+// configureLibusbContext disables usbfs device discovery on Android, where
+// apps are not permitted to scan /dev/bus/usb/* themselves and must instead
+// be handed an already-opened fd by the Java UsbManager.
+func configureLibusbContext(ctx *C.libusb_context) error {
+	return fromRawErrno(C.libusb_set_option(ctx, C.LIBUSB_OPTION_NO_DEVICE_DISCOVERY))
+}
+
+var (
+	wrappedMu      sync.Mutex
+	wrappedDevices []DeviceInfo
+)
+
+// rawDiscoverDevices can't scan usbfs on Android, so it instead returns
+// whatever devices have been handed in through OpenFromFileDescriptor.
+func rawDiscoverDevices(ctx *C.libusb_context, vendorID, productID uint16, skipHid bool) ([]DeviceInfo, error) {
+	wrappedMu.Lock()
+	defer wrappedMu.Unlock()
+
+	var infos []DeviceInfo
+	for _, info := range wrappedDevices {
+		if (vendorID > 0 && info.VendorID != vendorID) || (productID > 0 && info.ProductID != productID) {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// deregisterWrappedDevice removes the wrappedDevices entry identified by
+// rawDevice (the *C.libusb_device underlying a single OpenFromFileDescriptor
+// call), so Enumerate/EnumerateRaw stop returning it once its RawDevice is
+// closed. Matching by identity rather than vendor/product keeps repeated
+// open/close cycles of the same device from leaking or removing the wrong
+// entry.
+func deregisterWrappedDevice(rawDevice interface{}) {
+	wrappedMu.Lock()
+	defer wrappedMu.Unlock()
+
+	for i, info := range wrappedDevices {
+		if info.rawDevice == rawDevice {
+			wrappedDevices = append(wrappedDevices[:i], wrappedDevices[i+1:]...)
+			return
+		}
+	}
+}
+
+// OpenFromFileDescriptor wraps a USB device fd obtained through the Java
+// UsbManager.openDevice() API (typically handed down from a gomobile-embedded
+// Android app), calling libusb_wrap_sys_device so it can be driven through
+// the same RawDevice API as every other platform.
+func OpenFromFileDescriptor(fd int, vendorID, productID uint16) (*RawDevice, error) {
+	ctx, err := sharedLibusbContext()
+	if err != nil {
+		return nil, err
+	}
+	var handle *C.struct_libusb_device_handle
+	if err := fromRawErrno(C.libusb_wrap_sys_device(ctx, C.intptr_t(fd), (**C.struct_libusb_device_handle)(&handle))); err != nil {
+		return nil, fmt.Errorf("failed to wrap device fd %d: %v", fd, err)
+	}
+	dev := C.libusb_get_device(handle)
+
+	var desc C.struct_libusb_device_descriptor
+	if err := fromRawErrno(C.libusb_get_device_descriptor(dev, &desc)); err != nil {
+		C.libusb_close(handle)
+		return nil, fmt.Errorf("failed to read descriptor for fd %d: %v", fd, err)
+	}
+	infos, err := rawAltsettingInterfaces(dev, &desc)
+	if err != nil || len(infos) == 0 {
+		C.libusb_close(handle)
+		return nil, fmt.Errorf("no usable interface found on fd %d", fd)
+	}
+	info := infos[0]
+
+	wrappedMu.Lock()
+	wrappedDevices = append(wrappedDevices, info)
+	wrappedMu.Unlock()
+
+	return &RawDevice{
+		DeviceInfo: info,
+		handle:     handle,
+		ctx:        ctx,
+		closeHook:  func() { deregisterWrappedDevice(info.rawDevice) },
+	}, nil
+}
@@ -0,0 +1,143 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2022 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build freebsd,cgo linux,cgo darwin,!ios,cgo windows,cgo android,cgo
+
+package usb
+
+// #include "./libusb/libusb/libusb.h"
+import "C"
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// endpointType looks up the transfer type libusb reported for endpoint when
+// the device was enumerated, so Transfer knows which libusb_*_transfer call
+// to make.
+func (dev *RawDevice) endpointType(endpoint uint8) (EndpointType, error) {
+	for _, end := range dev.Endpoints {
+		if end.Address == endpoint {
+			return end.Type, nil
+		}
+	}
+	return 0, fmt.Errorf("usb: endpoint %#x not found on device", endpoint)
+}
+
+// Transfer issues a synchronous bulk or interrupt transfer on endpoint,
+// dispatching to libusb_bulk_transfer or libusb_interrupt_transfer depending
+// on the endpoint's type as reported during enumeration. It returns an
+// error for control or isochronous endpoints; use Control for the former,
+// and see EndpointTypeIsochronous for why the latter isn't supported here.
+func (dev *RawDevice) Transfer(endpoint uint8, buf []byte, timeout time.Duration) (int, error) {
+	kind, err := dev.endpointType(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := dev.acquireHandle()
+	if err != nil {
+		return 0, err
+	}
+	defer dev.releaseHandle()
+
+	var ptr *C.uchar
+	if len(buf) > 0 {
+		ptr = (*C.uchar)(unsafe.Pointer(&buf[0]))
+	}
+	var transferred C.int
+	switch kind {
+	case EndpointTypeBulk:
+		err = fromRawErrno(C.libusb_bulk_transfer(handle, C.uchar(endpoint), ptr, C.int(len(buf)), &transferred, C.uint(timeout.Milliseconds())))
+	case EndpointTypeInterrupt:
+		err = fromRawErrno(C.libusb_interrupt_transfer(handle, C.uchar(endpoint), ptr, C.int(len(buf)), &transferred, C.uint(timeout.Milliseconds())))
+	default:
+		return 0, fmt.Errorf("usb: endpoint %#x does not support bulk or interrupt transfers", endpoint)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(transferred), nil
+}
+
+// Control issues a synchronous control transfer on the device's default
+// control pipe, wrapping libusb_control_transfer.
+func (dev *RawDevice) Control(bmRequestType, bRequest uint8, wValue, wIndex uint16, data []byte, timeout time.Duration) (int, error) {
+	handle, err := dev.acquireHandle()
+	if err != nil {
+		return 0, err
+	}
+	defer dev.releaseHandle()
+
+	var ptr *C.uchar
+	if len(data) > 0 {
+		ptr = (*C.uchar)(unsafe.Pointer(&data[0]))
+	}
+	n := C.libusb_control_transfer(handle, C.uint8_t(bmRequestType), C.uint8_t(bRequest),
+		C.uint16_t(wValue), C.uint16_t(wIndex), ptr, C.uint16_t(len(data)), C.uint(timeout.Milliseconds()))
+	if n < 0 {
+		return 0, rawError(n)
+	}
+	return int(n), nil
+}
+
+// ClaimInterface claims exclusive access to iface, required before issuing
+// transfers against endpoints that belong to it.
+func (dev *RawDevice) ClaimInterface(iface int) error {
+	handle, err := dev.acquireHandle()
+	if err != nil {
+		return err
+	}
+	defer dev.releaseHandle()
+
+	return fromRawErrno(C.libusb_claim_interface(handle, C.int(iface)))
+}
+
+// ReleaseInterface releases a previously claimed interface.
+func (dev *RawDevice) ReleaseInterface(iface int) error {
+	handle, err := dev.acquireHandle()
+	if err != nil {
+		return err
+	}
+	defer dev.releaseHandle()
+
+	return fromRawErrno(C.libusb_release_interface(handle, C.int(iface)))
+}
+
+// SetConfiguration switches the device to the configuration identified by
+// its bConfigurationValue.
+func (dev *RawDevice) SetConfiguration(configuration int) error {
+	handle, err := dev.acquireHandle()
+	if err != nil {
+		return err
+	}
+	defer dev.releaseHandle()
+
+	return fromRawErrno(C.libusb_set_configuration(handle, C.int(configuration)))
+}
+
+// SetInterfaceAltSetting switches iface to the given alternate setting,
+// letting callers pick a non-default altsetting rather than being locked to
+// whichever one the enumerator happened to return first.
+func (dev *RawDevice) SetInterfaceAltSetting(iface, altSetting int) error {
+	handle, err := dev.acquireHandle()
+	if err != nil {
+		return err
+	}
+	defer dev.releaseHandle()
+
+	return fromRawErrno(C.libusb_set_interface_alt_setting(handle, C.int(iface), C.int(altSetting)))
+}
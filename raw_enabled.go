@@ -13,7 +13,7 @@
 // You should have received a copy of the GNU Lesser General Public License along
 // with the library. If not, see <http://www.gnu.org/licenses/>.
 
-// +build freebsd,cgo linux,cgo darwin,!ios,cgo windows,cgo
+// +build freebsd,cgo linux,cgo darwin,!ios,cgo windows,cgo android,cgo
 
 package usb
 
@@ -21,6 +21,7 @@ package usb
 import "C"
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"sync"
@@ -32,98 +33,101 @@ import (
 //  - If the vendor id is set to 0 then any vendor matches.
 //  - If the product id is set to 0 then any product matches.
 //  - If the vendor and product id are both 0, all USB devices are returned.
+//
+// Device discovery itself is platform specific: everywhere libusb can walk
+// usbfs/WinUSB it is scanned directly (rawDiscoverDevices in
+// raw_enumerate_posix.go); on Android, where apps cannot open
+// /dev/bus/usb/* and discovery is disabled at the libusb level, it instead
+// returns whatever devices were handed in through OpenFromFileDescriptor
+// (raw_android.go).
 func enumerateRaw(vendorID uint16, productID uint16, skipHid bool) ([]DeviceInfo, error) {
-	// Create a context to interact with USB devices through
-	var ctx *C.libusb_context
-	errCode := int(C.libusb_init((**C.libusb_context)(&ctx)))
-	if errCode < 0 {
-		return nil, fmt.Errorf("Error while initializing libusb: %d", errCode)
-	}
-	// Retrieve all the available USB devices and wrap them in Go
-	var deviceList **C.libusb_device
-	count := C.libusb_get_device_list(ctx, &deviceList)
-	if count < 0 {
-		return nil, rawError(count)
+	ctx, err := sharedLibusbContext()
+	if err != nil {
+		return nil, err
 	}
-	defer C.libusb_free_device_list(deviceList, 1)
+	return rawDiscoverDevices(ctx, vendorID, productID, skipHid)
+}
 
-	var devices []*C.libusb_device
-	*(*reflect.SliceHeader)(unsafe.Pointer(&devices)) = reflect.SliceHeader{
-		Data: uintptr(unsafe.Pointer(deviceList)),
-		Len:  int(count),
-		Cap:  int(count),
-	}
-	//
+// rawAltsettingInterfaces walks every configuration/interface/altsetting of
+// dev and returns a DeviceInfo for each altsetting that exposes at least one
+// endpoint, populated with the full set of endpoint descriptors it offers.
+// For backwards compatibility with Read/Write, rawReader/rawWriter are also
+// set to the first interrupt IN/OUT endpoint found, if any. It is shared
+// between enumerateRaw and the hotplug arrival callback, which both need to
+// turn a bare *C.libusb_device into the DeviceInfo values the rest of the
+// package deals with.
+func rawAltsettingInterfaces(dev *C.libusb_device, desc *C.struct_libusb_device_descriptor) ([]DeviceInfo, error) {
 	var infos []DeviceInfo
-	for devnum, dev := range devices {
-		// Retrieve the libusb device descriptor and skip non-queried ones
-		var desc C.struct_libusb_device_descriptor
-		if err := fromRawErrno(C.libusb_get_device_descriptor(dev, &desc)); err != nil {
-			return nil, fmt.Errorf("failed to get device %d descriptor: %v", devnum, err)
+	for cfgnum := 0; cfgnum < int(desc.bNumConfigurations); cfgnum++ {
+		// Retrieve the all the possible USB configurations of the device
+		var cfg *C.struct_libusb_config_descriptor
+		if err := fromRawErrno(C.libusb_get_config_descriptor(dev, C.uint8_t(cfgnum), &cfg)); err != nil {
+			return nil, fmt.Errorf("failed to get config %d: %v", cfgnum, err)
 		}
-		if (vendorID > 0 && uint16(desc.idVendor) != vendorID) || (productID > 0 && uint16(desc.idProduct) != productID) {
-			continue
+		var ifaces []C.struct_libusb_interface
+		*(*reflect.SliceHeader)(unsafe.Pointer(&ifaces)) = reflect.SliceHeader{
+			Data: uintptr(unsafe.Pointer(cfg._interface)),
+			Len:  int(cfg.bNumInterfaces),
+			Cap:  int(cfg.bNumInterfaces),
 		}
-		// Skip HID devices if requested, they will be handled later
-		if skipHid && desc.bDeviceClass == C.LIBUSB_CLASS_HID {
-			continue
-		}
-		// Iterate over all the configurations and find raw interfaces
-		for cfgnum := 0; cfgnum < int(desc.bNumConfigurations); cfgnum++ {
-			// Retrieve the all the possible USB configurations of the device
-			var cfg *C.struct_libusb_config_descriptor
-			if err := fromRawErrno(C.libusb_get_config_descriptor(dev, C.uint8_t(cfgnum), &cfg)); err != nil {
-				return nil, fmt.Errorf("failed to get device %d config %d: %v", devnum, cfgnum, err)
+		// Drill down into each advertised interface
+		for ifacenum, iface := range ifaces {
+			if iface.num_altsetting == 0 {
+				continue
 			}
-			var ifaces []C.struct_libusb_interface
-			*(*reflect.SliceHeader)(unsafe.Pointer(&ifaces)) = reflect.SliceHeader{
-				Data: uintptr(unsafe.Pointer(cfg._interface)),
-				Len:  int(cfg.bNumInterfaces),
-				Cap:  int(cfg.bNumInterfaces),
+			var alts []C.struct_libusb_interface_descriptor
+			*(*reflect.SliceHeader)(unsafe.Pointer(&alts)) = reflect.SliceHeader{
+				Data: uintptr(unsafe.Pointer(iface.altsetting)),
+				Len:  int(iface.num_altsetting),
+				Cap:  int(iface.num_altsetting),
 			}
-			// Drill down into each advertised interface
-			for ifacenum, iface := range ifaces {
-				if iface.num_altsetting == 0 {
-					continue
+			for altnum, alt := range alts {
+				var ends []C.struct_libusb_endpoint_descriptor
+				*(*reflect.SliceHeader)(unsafe.Pointer(&ends)) = reflect.SliceHeader{
+					Data: uintptr(unsafe.Pointer(alt.endpoint)),
+					Len:  int(alt.bNumEndpoints),
+					Cap:  int(alt.bNumEndpoints),
 				}
-				var alts []C.struct_libusb_interface_descriptor
-				*(*reflect.SliceHeader)(unsafe.Pointer(&alts)) = reflect.SliceHeader{
-					Data: uintptr(unsafe.Pointer(iface.altsetting)),
-					Len:  int(iface.num_altsetting),
-					Cap:  int(iface.num_altsetting),
+				if len(ends) == 0 {
+					continue
 				}
-				for _, alt := range alts {
-					// Find the endpoints that can speak libusb interrupts
-					var ends []C.struct_libusb_endpoint_descriptor
-					*(*reflect.SliceHeader)(unsafe.Pointer(&ends)) = reflect.SliceHeader{
-						Data: uintptr(unsafe.Pointer(alt.endpoint)),
-						Len:  int(alt.bNumEndpoints),
-						Cap:  int(alt.bNumEndpoints),
-					}
-					var reader, writer *uint8
-					for _, end := range ends {
-						switch {
-						case end.bEndpointAddress&C.LIBUSB_ENDPOINT_OUT == C.LIBUSB_ENDPOINT_OUT && end.bmAttributes == C.LIBUSB_TRANSFER_TYPE_INTERRUPT:
-							writer = new(uint8)
-							*writer = uint8(end.bEndpointAddress)
-						case end.bEndpointAddress&C.LIBUSB_ENDPOINT_IN == C.LIBUSB_ENDPOINT_IN && end.bmAttributes == C.LIBUSB_TRANSFER_TYPE_INTERRUPT:
-							reader = new(uint8)
-							*reader = uint8(end.bEndpointAddress)
-						}
-					}
-					// If both in and out interrupts are available, match the device
-					if reader != nil && writer != nil {
-						infos = append(infos, DeviceInfo{
-							Path:      fmt.Sprintf("%x:%x:%d", vendorID, uint16(desc.idProduct), uint8(C.libusb_get_port_number(dev))),
-							VendorID:  uint16(desc.idVendor),
-							ProductID: uint16(desc.idProduct),
-							Interface: ifacenum,
-							rawDevice: dev,
-							rawReader: reader,
-							rawWriter: writer,
-						})
+				var reader, writer *uint8
+				endpoints := make([]EndpointInfo, 0, len(ends))
+				for _, end := range ends {
+					endpoints = append(endpoints, EndpointInfo{
+						Address:       uint8(end.bEndpointAddress),
+						Type:          EndpointType(end.bmAttributes & C.LIBUSB_TRANSFER_TYPE_MASK),
+						MaxPacketSize: uint16(end.wMaxPacketSize),
+						Interval:      uint8(end.bInterval),
+					})
+					switch {
+					case writer == nil && end.bEndpointAddress&C.LIBUSB_ENDPOINT_DIR_MASK == C.LIBUSB_ENDPOINT_OUT && end.bmAttributes == C.LIBUSB_TRANSFER_TYPE_INTERRUPT:
+						writer = new(uint8)
+						*writer = uint8(end.bEndpointAddress)
+					case reader == nil && end.bEndpointAddress&C.LIBUSB_ENDPOINT_IN == C.LIBUSB_ENDPOINT_IN && end.bmAttributes == C.LIBUSB_TRANSFER_TYPE_INTERRUPT:
+						reader = new(uint8)
+						*reader = uint8(end.bEndpointAddress)
 					}
 				}
+				// Include the interface number, and the altsetting index when
+				// the interface has more than one altsetting, so composite devices
+				// that expose several interfaces (or altsettings) off the
+				// same port don't collide on Path: watchRawPolling keys its
+				// arrival/departure diff entirely by it.
+				path := fmt.Sprintf("%x:%x:%d:%d", uint16(desc.idVendor), uint16(desc.idProduct), uint8(C.libusb_get_port_number(dev)), ifacenum)
+				if len(alts) > 1 {
+					path = fmt.Sprintf("%s:%d", path, altnum)
+				}
+				infos = append(infos, DeviceInfo{
+					Path:      path,
+					VendorID:  uint16(desc.idVendor),
+					ProductID: uint16(desc.idProduct),
+					Interface: ifacenum,
+					Endpoints: endpoints,
+					rawDevice: dev,
+					rawReader: reader,
+					rawWriter: writer,
+				})
 			}
 		}
 	}
@@ -132,6 +136,10 @@ func enumerateRaw(vendorID uint16, productID uint16, skipHid bool) ([]DeviceInfo
 
 // openRaw connects to a low level libusb device by its path name.
 func openRaw(info DeviceInfo) (*RawDevice, error) {
+	ctx, err := sharedLibusbContext()
+	if err != nil {
+		return nil, err
+	}
 	var handle *C.struct_libusb_device_handle
 	if err := fromRawErrno(C.libusb_open(info.rawDevice.(*C.libusb_device), (**C.struct_libusb_device_handle)(&handle))); err != nil {
 		return nil, fmt.Errorf("failed to open device: %v", err)
@@ -139,6 +147,7 @@ func openRaw(info DeviceInfo) (*RawDevice, error) {
 	return &RawDevice{
 		DeviceInfo: info,
 		handle:     handle,
+		ctx:        ctx,
 	}, nil
 }
 
@@ -147,41 +156,133 @@ type RawDevice struct {
 	DeviceInfo // Embed the infos for easier access
 
 	handle *C.struct_libusb_device_handle // Low level USB device to communicate through
+	ctx    *C.libusb_context              // Shared libusb context backing the async transfer event pump
 	lock   sync.Mutex
+	closed bool
+
+	active sync.WaitGroup // Outstanding calls holding handle, Close waits for these before closing it
+
+	transfersMu sync.Mutex
+	transfers   map[*Transfer]struct{} // Live async transfers, so Close can cancel them to unblock active.Wait
+
+	closeHook func() // Platform-specific cleanup run once the handle is closed, e.g. Android's wrapped-device bookkeeping
 }
 
-// Close releases the raw USB device handle.
-func (dev *RawDevice) Close() error {
+// acquireHandle returns the device's libusb handle for the duration of a
+// single synchronous or asynchronous call, and marks that call as active so
+// Close knows to wait for it before calling libusb_close. It must be paired
+// with exactly one releaseHandle once the call is done with handle; for
+// SubmitAsync that happens in the completion callback, not in Wait.
+func (dev *RawDevice) acquireHandle() (*C.struct_libusb_device_handle, error) {
 	dev.lock.Lock()
 	defer dev.lock.Unlock()
 
-	if dev.handle != nil {
-		C.libusb_close(dev.handle)
-		dev.handle = nil
+	if dev.closed || dev.handle == nil {
+		return nil, fmt.Errorf("usb: device closed")
 	}
-	return nil
+	dev.active.Add(1)
+	return dev.handle, nil
 }
 
-// Write sends a binary blob to a low level USB device.
-func (dev *RawDevice) Write(b []byte) (int, error) {
+// releaseHandle marks a call started by acquireHandle as finished.
+func (dev *RawDevice) releaseHandle() {
+	dev.active.Done()
+}
+
+// registerTransfer tracks an in-flight async transfer so Close can cancel it.
+func (dev *RawDevice) registerTransfer(t *Transfer) {
+	dev.transfersMu.Lock()
+	defer dev.transfersMu.Unlock()
+
+	if dev.transfers == nil {
+		dev.transfers = make(map[*Transfer]struct{})
+	}
+	dev.transfers[t] = struct{}{}
+}
+
+// deregisterTransfer stops tracking a transfer once it has completed.
+func (dev *RawDevice) deregisterTransfer(t *Transfer) {
+	dev.transfersMu.Lock()
+	defer dev.transfersMu.Unlock()
+
+	delete(dev.transfers, t)
+}
+
+// Close releases the raw USB device handle. It cancels every outstanding
+// async transfer and blocks until all in-flight calls against the handle
+// (synchronous or asynchronous) have returned, so libusb_close never races
+// with a transfer still using the handle.
+func (dev *RawDevice) Close() error {
 	dev.lock.Lock()
-	defer dev.lock.Unlock()
+	if dev.closed {
+		dev.lock.Unlock()
+		return nil
+	}
+	dev.closed = true
+	handle := dev.handle
+	dev.lock.Unlock()
+
+	dev.transfersMu.Lock()
+	for t := range dev.transfers {
+		C.libusb_cancel_transfer(t.raw)
+	}
+	dev.transfersMu.Unlock()
+
+	dev.active.Wait()
 
-	var transferred C.int
-	if err := fromRawErrno(C.libusb_interrupt_transfer(dev.handle, (C.uchar)(*dev.rawWriter), (*C.uchar)(&b[0]), (C.int)(len(b)), &transferred, (C.uint)(0))); err != nil {
+	dev.lock.Lock()
+	if handle != nil {
+		C.libusb_close(handle)
+		dev.handle = nil
+	}
+	dev.lock.Unlock()
+
+	if dev.closeHook != nil {
+		dev.closeHook()
+	}
+	return nil
+}
+
+// WriteContext sends a binary blob to a low level USB device, submitting it
+// through the async transfer subsystem and cancelling it if ctx is done
+// before it completes. It returns an error if the device has no interrupt
+// OUT endpoint to write with; bulk/control-only devices must use Transfer
+// or Control instead.
+func (dev *RawDevice) WriteContext(ctx context.Context, b []byte) (int, error) {
+	if dev.rawWriter == nil {
+		return 0, fmt.Errorf("usb: device has no interrupt OUT endpoint, use Transfer or Control instead")
+	}
+	transfer, err := dev.SubmitAsync(*dev.rawWriter, b)
+	if err != nil {
 		return 0, err
 	}
-	return int(transferred), nil
+	return transfer.Wait(ctx)
 }
 
-// Read retrieves a binary blob from a low level USB device.
-func (dev *RawDevice) Read(b []byte) (int, error) {
-	dev.lock.Lock()
-	defer dev.lock.Unlock()
+// Write sends a binary blob to a low level USB device. It blocks forever,
+// equivalent to WriteContext with context.Background().
+func (dev *RawDevice) Write(b []byte) (int, error) {
+	return dev.WriteContext(context.Background(), b)
+}
 
-	var transferred C.int
-	if err := fromRawErrno(C.libusb_interrupt_transfer(dev.handle, (C.uchar)(*dev.rawReader), (*C.uchar)(&b[0]), (C.int)(len(b)), &transferred, (C.uint)(0))); err != nil {
+// ReadContext retrieves a binary blob from a low level USB device,
+// submitting it through the async transfer subsystem and cancelling it if
+// ctx is done before it completes. It returns an error if the device has no
+// interrupt IN endpoint to read from; bulk/control-only devices must use
+// Transfer or Control instead.
+func (dev *RawDevice) ReadContext(ctx context.Context, b []byte) (int, error) {
+	if dev.rawReader == nil {
+		return 0, fmt.Errorf("usb: device has no interrupt IN endpoint, use Transfer or Control instead")
+	}
+	transfer, err := dev.SubmitAsync(*dev.rawReader, b)
+	if err != nil {
 		return 0, err
 	}
-	return int(transferred), nil
+	return transfer.Wait(ctx)
+}
+
+// Read retrieves a binary blob from a low level USB device. It blocks
+// forever, equivalent to ReadContext with context.Background().
+func (dev *RawDevice) Read(b []byte) (int, error) {
+	return dev.ReadContext(context.Background(), b)
 }
@@ -0,0 +1,23 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2026 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build freebsd,cgo linux,cgo darwin,!ios,cgo windows,cgo android,cgo
+
+package usb
+
+// var _ Device asserts at compile time that RawDevice keeps satisfying
+// Device, in particular the context-aware ReadContext/WriteContext pair
+// Device grew alongside it.
+var _ Device = (*RawDevice)(nil)
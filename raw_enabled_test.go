@@ -0,0 +1,37 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2026 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build freebsd,cgo linux,cgo darwin,!ios,cgo windows,cgo android,cgo
+
+package usb
+
+import (
+	"context"
+	"testing"
+)
+
+// Tests that a bulk/control-only DeviceInfo (no interrupt endpoint, so
+// rawReader/rawWriter are nil) makes ReadContext/WriteContext return an
+// error instead of dereferencing a nil endpoint pointer.
+func TestReadWriteContextNilEndpoint(t *testing.T) {
+	dev := &RawDevice{}
+
+	if _, err := dev.WriteContext(context.Background(), []byte("x")); err == nil {
+		t.Error("WriteContext with nil rawWriter: got nil error, want one")
+	}
+	if _, err := dev.ReadContext(context.Background(), make([]byte, 1)); err == nil {
+		t.Error("ReadContext with nil rawReader: got nil error, want one")
+	}
+}
@@ -0,0 +1,75 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2023 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build freebsd,cgo linux,cgo,!android darwin,!ios,cgo windows,cgo
+
+package usb
+
+// #include "./libusb/libusb/libusb.h"
+import "C"
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// configureLibusbContext is a no-op on every platform that can discover
+// devices by itself; Android overrides this in raw_android.go to disable
+// usbfs discovery at init time.
+func configureLibusbContext(ctx *C.libusb_context) error {
+	return nil
+}
+
+// rawDiscoverDevices walks every USB device libusb can see through ctx and
+// returns the matching, enumerable interfaces.
+func rawDiscoverDevices(ctx *C.libusb_context, vendorID, productID uint16, skipHid bool) ([]DeviceInfo, error) {
+	// Retrieve all the available USB devices and wrap them in Go
+	var deviceList **C.libusb_device
+	count := C.libusb_get_device_list(ctx, &deviceList)
+	if count < 0 {
+		return nil, rawError(count)
+	}
+	defer C.libusb_free_device_list(deviceList, 1)
+
+	var devices []*C.libusb_device
+	*(*reflect.SliceHeader)(unsafe.Pointer(&devices)) = reflect.SliceHeader{
+		Data: uintptr(unsafe.Pointer(deviceList)),
+		Len:  int(count),
+		Cap:  int(count),
+	}
+
+	var infos []DeviceInfo
+	for devnum, dev := range devices {
+		// Retrieve the libusb device descriptor and skip non-queried ones
+		var desc C.struct_libusb_device_descriptor
+		if err := fromRawErrno(C.libusb_get_device_descriptor(dev, &desc)); err != nil {
+			return nil, fmt.Errorf("failed to get device %d descriptor: %v", devnum, err)
+		}
+		if (vendorID > 0 && uint16(desc.idVendor) != vendorID) || (productID > 0 && uint16(desc.idProduct) != productID) {
+			continue
+		}
+		// Skip HID devices if requested, they will be handled later
+		if skipHid && desc.bDeviceClass == C.LIBUSB_CLASS_HID {
+			continue
+		}
+		found, err := rawAltsettingInterfaces(dev, &desc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect device %d: %v", devnum, err)
+		}
+		infos = append(infos, found...)
+	}
+	return infos, nil
+}
@@ -0,0 +1,217 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2022 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build freebsd,cgo linux,cgo darwin,!ios,cgo windows,cgo
+
+package usb
+
+// #include "./libusb/libusb/libusb.h"
+// extern int usbHotplugCallback(libusb_context *ctx, libusb_device *dev, libusb_hotplug_event event, void *user_data);
+import "C"
+
+import (
+	"fmt"
+	"runtime/cgo"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// watchRaw subscribes to hotplug notifications, preferring libusb's native
+// hotplug support and falling back to polling enumerateRaw where the
+// platform's libusb build lacks it (notably Windows).
+func watchRaw(events HotplugEventKind, vendorID, productID uint16) (<-chan HotplugEvent, func(), error) {
+	ctx, err := sharedLibusbContext()
+	if err != nil {
+		return nil, nil, err
+	}
+	if C.libusb_has_capability(C.LIBUSB_CAP_HAS_HOTPLUG) != 0 {
+		return watchRawNative(ctx, events, vendorID, productID)
+	}
+	return watchRawPolling(events, vendorID, productID)
+}
+
+// watchRawNative registers a libusb_hotplug callback and forwards the
+// resulting arrival/departure notifications onto a Go channel.
+//
+// libusb only ever invokes hotplug callbacks from inside
+// libusb_handle_events*, which nothing but the shared eventPump calls. The
+// pump is otherwise only kept alive by in-flight transfers (SubmitAsync), so
+// a hotplug-only subscriber would never see a callback fire; acquire a
+// reference here for the life of the subscription instead.
+func watchRawNative(ctx *C.libusb_context, events HotplugEventKind, vendorID, productID uint16) (<-chan HotplugEvent, func(), error) {
+	pump := acquireEventPump(ctx)
+
+	sub := &hotplugSubscription{
+		events: events,
+		sink:   make(chan HotplugEvent, hotplugBacklog),
+	}
+	sub.handle = cgo.NewHandle(sub)
+
+	var cevents C.int
+	if events&HotplugArrived != 0 {
+		cevents |= C.LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED
+	}
+	if events&HotplugLeft != 0 {
+		cevents |= C.LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT
+	}
+	vendor, product := C.int(C.LIBUSB_HOTPLUG_MATCH_ANY), C.int(C.LIBUSB_HOTPLUG_MATCH_ANY)
+	if vendorID > 0 {
+		vendor = C.int(vendorID)
+	}
+	if productID > 0 {
+		product = C.int(productID)
+	}
+
+	var handle C.libusb_hotplug_callback_handle
+	errCode := C.libusb_hotplug_register_callback(ctx, C.int(cevents), C.LIBUSB_HOTPLUG_ENUMERATE,
+		vendor, product, C.LIBUSB_HOTPLUG_MATCH_ANY,
+		C.libusb_hotplug_callback_fn(C.usbHotplugCallback), unsafe.Pointer(uintptr(sub.handle)), &handle)
+	if errCode < 0 {
+		sub.handle.Delete()
+		pump.release()
+		return nil, nil, fmt.Errorf("usb: failed to register hotplug callback: %d", errCode)
+	}
+	sub.deregister = func() { C.libusb_hotplug_deregister_callback(ctx, handle) }
+
+	// libusb_hotplug_deregister_callback only stops *new* invocations; it
+	// does not block for one already running, since the callback is invoked
+	// with libusb's internal hotplug lock released. Rendezvous with
+	// sub.running before tearing anything the callback touches down, so a
+	// callback that was already mid-flight when stop ran can never send on
+	// sub.sink (or resolve sub.handle) after it's gone.
+	stop := func() {
+		sub.deregister()
+		sub.running.Wait()
+
+		sub.mu.Lock()
+		sub.closed = true
+		sub.mu.Unlock()
+
+		sub.handle.Delete()
+		pump.release()
+		close(sub.sink)
+	}
+	return sub.sink, stop, nil
+}
+
+// hotplugSubscription carries the state a registered libusb hotplug
+// callback needs in order to turn a raw event into a HotplugEvent and
+// deliver it without blocking libusb's event thread.
+type hotplugSubscription struct {
+	events     HotplugEventKind
+	sink       chan HotplugEvent
+	handle     cgo.Handle
+	deregister func()
+
+	running sync.WaitGroup // Outstanding usbHotplugCallback invocations; stop waits for this to drain
+	mu      sync.Mutex
+	closed  bool // Set once stop has drained running, so a callback racing it can't tell and get through anyway
+}
+
+//export usbHotplugCallback
+func usbHotplugCallback(ctx *C.libusb_context, dev *C.libusb_device, event C.libusb_hotplug_event, userData unsafe.Pointer) C.int {
+	handle := cgo.Handle(uintptr(userData))
+	sub, ok := handle.Value().(*hotplugSubscription)
+	if !ok {
+		return 0
+	}
+	sub.running.Add(1)
+	defer sub.running.Done()
+
+	sub.mu.Lock()
+	closed := sub.closed
+	sub.mu.Unlock()
+	if closed {
+		return 0
+	}
+
+	var desc C.struct_libusb_device_descriptor
+	if fromRawErrno(C.libusb_get_device_descriptor(dev, &desc)) != nil {
+		return 0
+	}
+
+	switch event {
+	case C.LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED:
+		if sub.events&HotplugArrived == 0 {
+			return 0
+		}
+		infos, err := rawAltsettingInterfaces(dev, &desc)
+		if err != nil {
+			return 0
+		}
+		for _, info := range infos {
+			dropOldestSend(sub.sink, HotplugEvent{Info: info, Kind: HotplugArrived})
+		}
+	case C.LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT:
+		if sub.events&HotplugLeft == 0 {
+			return 0
+		}
+		dropOldestSend(sub.sink, HotplugEvent{
+			Info: DeviceInfo{VendorID: uint16(desc.idVendor), ProductID: uint16(desc.idProduct)},
+			Kind: HotplugLeft,
+		})
+	}
+	return 0
+}
+
+// hotplugPollInterval is how often watchRawPolling re-enumerates devices
+// when libusb has no native hotplug support for the running platform.
+const hotplugPollInterval = 2 * time.Second
+
+// watchRawPolling emulates hotplug notifications by periodically
+// re-enumerating raw devices and diffing against the previous snapshot.
+// This is the only option on platforms such as Windows where libusb has no
+// native hotplug backend.
+func watchRawPolling(events HotplugEventKind, vendorID, productID uint16) (<-chan HotplugEvent, func(), error) {
+	sink := make(chan HotplugEvent, hotplugBacklog)
+	stopping := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		seen := make(map[string]DeviceInfo)
+		ticker := time.NewTicker(hotplugPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopping:
+				return
+			case <-ticker.C:
+			}
+			infos, err := enumerateRaw(vendorID, productID, false)
+			if err != nil {
+				continue
+			}
+			current, arrived, left := diffHotplugSnapshot(seen, infos, events)
+			for _, info := range arrived {
+				dropOldestSend(sink, HotplugEvent{Info: info, Kind: HotplugArrived})
+			}
+			for _, info := range left {
+				dropOldestSend(sink, HotplugEvent{Info: info, Kind: HotplugLeft})
+			}
+			seen = current
+		}
+	}()
+
+	stop := func() {
+		close(stopping)
+		<-stopped
+		close(sink)
+	}
+	return sink, stop, nil
+}
@@ -0,0 +1,262 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2021 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build freebsd,cgo linux,cgo darwin,!ios,cgo windows,cgo android,cgo
+
+package usb
+
+// #include "./libusb/libusb/libusb.h"
+// extern void usbTransferCallback(struct libusb_transfer *transfer);
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+)
+
+var (
+	sharedContextOnce sync.Once
+	sharedContext     *C.libusb_context
+	sharedContextErr  error
+)
+
+// sharedLibusbContext returns the single libusb_context backing the async
+// transfer subsystem, lazily initializing it on first use. Every RawDevice
+// shares this context so that one event pump goroutine can service all of
+// their in-flight transfers instead of spinning up a thread per device.
+func sharedLibusbContext() (*C.libusb_context, error) {
+	sharedContextOnce.Do(func() {
+		var ctx *C.libusb_context
+		if errCode := int(C.libusb_init((**C.libusb_context)(&ctx))); errCode < 0 {
+			sharedContextErr = fmt.Errorf("failed to initialize libusb: %d", errCode)
+			return
+		}
+		if err := configureLibusbContext(ctx); err != nil {
+			sharedContextErr = err
+			return
+		}
+		sharedContext = ctx
+	})
+	return sharedContext, sharedContextErr
+}
+
+// eventPump drives libusb_handle_events for a single libusb_context on a
+// dedicated, OS-thread-locked goroutine, so that in-flight transfers keep
+// completing even while every other goroutine is busy elsewhere. Pumps are
+// reference counted, by in-flight transfers and native hotplug
+// subscriptions, and shut down once the last reference is released.
+type eventPump struct {
+	ctx *C.libusb_context
+
+	closing chan struct{}
+	closed  chan struct{}
+
+	mu   sync.Mutex
+	refs int
+}
+
+var (
+	pumpsMu sync.Mutex
+	pumps   = make(map[*C.libusb_context]*eventPump)
+)
+
+// acquireEventPump returns the event pump for ctx, starting one if this is
+// the first transfer or hotplug subscription to need it.
+func acquireEventPump(ctx *C.libusb_context) *eventPump {
+	pumpsMu.Lock()
+	defer pumpsMu.Unlock()
+
+	pump, ok := pumps[ctx]
+	if !ok {
+		pump = &eventPump{
+			ctx:     ctx,
+			closing: make(chan struct{}),
+			closed:  make(chan struct{}),
+		}
+		pumps[ctx] = pump
+		go pump.loop()
+	}
+	pump.refs++
+	return pump
+}
+
+// release drops a reference to the pump, tearing it down once no transfer
+// or hotplug subscription needs it any more.
+func (p *eventPump) release() {
+	pumpsMu.Lock()
+	p.refs--
+	empty := p.refs == 0
+	if empty {
+		delete(pumps, p.ctx)
+	}
+	pumpsMu.Unlock()
+
+	if empty {
+		close(p.closing)
+		<-p.closed
+	}
+}
+
+// loop repeatedly calls libusb_handle_events_timeout_completed until the
+// pump is released, waking the calling completion callbacks as transfers
+// finish.
+func (p *eventPump) loop() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer close(p.closed)
+
+	tv := C.struct_timeval{tv_sec: 0, tv_usec: 100000} // Poll for shutdown every 100ms
+	for {
+		select {
+		case <-p.closing:
+			return
+		default:
+		}
+		var completed C.int
+		C.libusb_handle_events_timeout_completed(p.ctx, &tv, &completed)
+	}
+}
+
+// Transfer is a handle to an in-flight asynchronous USB transfer submitted
+// through RawDevice.SubmitAsync. Callers must call Wait exactly once to
+// release the underlying libusb_transfer.
+type Transfer struct {
+	raw    *C.struct_libusb_transfer
+	handle cgo.Handle
+	pump   *eventPump
+	dev    *RawDevice
+
+	done   chan struct{}
+	result int
+	status C.enum_libusb_transfer_status
+}
+
+//export usbTransferCallback
+func usbTransferCallback(raw *C.struct_libusb_transfer) {
+	handle := cgo.Handle(uintptr(raw.user_data))
+	transfer, ok := handle.Value().(*Transfer)
+	if !ok {
+		return
+	}
+	transfer.result = int(raw.actual_length)
+	transfer.status = raw.status
+
+	// Release this transfer's hold on the device handle as soon as libusb
+	// reports completion, regardless of whether the caller ever calls Wait,
+	// so a leaked Transfer can't make RawDevice.Close block forever.
+	transfer.dev.deregisterTransfer(transfer)
+	transfer.dev.releaseHandle()
+
+	close(transfer.done)
+}
+
+// SubmitAsync queues an interrupt transfer on endpoint and returns
+// immediately with a handle that completes once the shared event pump
+// observes the completion callback. buf must stay alive and untouched by
+// the caller until Wait returns.
+//
+// The returned Transfer holds a reference on dev's handle until libusb
+// reports its completion (or cancellation), so a concurrent Close blocks
+// until then rather than closing the handle out from under it.
+func (dev *RawDevice) SubmitAsync(endpoint uint8, buf []byte) (*Transfer, error) {
+	handle, err := dev.acquireHandle()
+	if err != nil {
+		return nil, err
+	}
+	raw := C.libusb_alloc_transfer(0)
+	if raw == nil {
+		dev.releaseHandle()
+		return nil, fmt.Errorf("usb: failed to allocate transfer")
+	}
+	transfer := &Transfer{
+		raw:  raw,
+		pump: acquireEventPump(dev.ctx),
+		dev:  dev,
+		done: make(chan struct{}),
+	}
+	transfer.handle = cgo.NewHandle(transfer)
+
+	var ptr *C.uchar
+	if len(buf) > 0 {
+		ptr = (*C.uchar)(unsafe.Pointer(&buf[0]))
+	}
+	C.libusb_fill_interrupt_transfer(raw, handle, C.uchar(endpoint), ptr, C.int(len(buf)),
+		C.libusb_transfer_cb_fn(C.usbTransferCallback), unsafe.Pointer(uintptr(transfer.handle)), 0)
+
+	// Register before submitting: the callback may fire on the pump thread
+	// before libusb_submit_transfer even returns, and its deregistration
+	// must not run ahead of the registration.
+	dev.registerTransfer(transfer)
+	if err := fromRawErrno(C.libusb_submit_transfer(raw)); err != nil {
+		dev.deregisterTransfer(transfer)
+		transfer.handle.Delete()
+		transfer.pump.release()
+		C.libusb_free_transfer(raw)
+		dev.releaseHandle()
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// Wait blocks until the transfer completes or ctx is done, in which case the
+// transfer is cancelled via libusb_cancel_transfer and Wait blocks for the
+// cancellation to be acknowledged before returning. It always releases the
+// underlying libusb_transfer, so it must only be called once.
+func (t *Transfer) Wait(ctx context.Context) (int, error) {
+	defer func() {
+		t.handle.Delete()
+		t.pump.release()
+		C.libusb_free_transfer(t.raw)
+	}()
+
+	select {
+	case <-t.done:
+		return t.result, transferStatusError(t.status)
+	case <-ctx.Done():
+	}
+
+	C.libusb_cancel_transfer(t.raw)
+	<-t.done // The callback always fires, even for a cancelled transfer
+
+	if err := ctx.Err(); err != nil {
+		return t.result, err
+	}
+	return t.result, transferStatusError(t.status)
+}
+
+// transferStatusError converts a libusb_transfer_status into the matching Go
+// error, returning nil for a clean completion.
+func transferStatusError(status C.enum_libusb_transfer_status) error {
+	switch status {
+	case C.LIBUSB_TRANSFER_COMPLETED:
+		return nil
+	case C.LIBUSB_TRANSFER_CANCELLED:
+		return context.Canceled
+	case C.LIBUSB_TRANSFER_TIMED_OUT:
+		return context.DeadlineExceeded
+	case C.LIBUSB_TRANSFER_STALL:
+		return fmt.Errorf("usb: transfer stalled")
+	case C.LIBUSB_TRANSFER_NO_DEVICE:
+		return fmt.Errorf("usb: device disconnected")
+	case C.LIBUSB_TRANSFER_OVERFLOW:
+		return fmt.Errorf("usb: transfer overflow")
+	default:
+		return fmt.Errorf("usb: transfer error: %d", status)
+	}
+}
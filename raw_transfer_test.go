@@ -0,0 +1,56 @@
+// usb - Self contained USB and HID library for Go
+// Copyright 2026 The library Authors
+//
+// This library is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Lesser General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option) any
+// later version.
+//
+// The library is distributed in the hope that it will be useful, but WITHOUT ANY
+// WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+// A PARTICULAR PURPOSE. See the GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License along
+// with the library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build freebsd,cgo linux,cgo darwin,!ios,cgo windows,cgo android,cgo
+
+package usb
+
+// #include "./libusb/libusb/libusb.h"
+import "C"
+
+import (
+	"context"
+	"testing"
+)
+
+// Tests that transferStatusError maps a clean completion to nil, cancellation
+// and timeout to the matching context errors, and the stall, disconnect and
+// overflow statuses to non-nil errors.
+func TestTransferStatusError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status C.enum_libusb_transfer_status
+		want   error
+	}{
+		{"completed", C.LIBUSB_TRANSFER_COMPLETED, nil},
+		{"cancelled", C.LIBUSB_TRANSFER_CANCELLED, context.Canceled},
+		{"timed out", C.LIBUSB_TRANSFER_TIMED_OUT, context.DeadlineExceeded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := transferStatusError(test.status); err != test.want {
+				t.Errorf("transferStatusError(%v) = %v, want %v", test.status, err, test.want)
+			}
+		})
+	}
+
+	for _, status := range []C.enum_libusb_transfer_status{
+		C.LIBUSB_TRANSFER_STALL, C.LIBUSB_TRANSFER_NO_DEVICE, C.LIBUSB_TRANSFER_OVERFLOW,
+	} {
+		if err := transferStatusError(status); err == nil {
+			t.Errorf("transferStatusError(%v) = nil, want a non-nil error", status)
+		}
+	}
+}
@@ -16,6 +16,10 @@
 // Package usb provide interfaces for generic USB devices.
 package usb
 
+//go:generate go run ./internal/vendorlibs
+
+import "context"
+
 // DeviceType represents the type of a USB device (generic or HID)
 type DeviceType int
 
@@ -57,6 +61,14 @@ type Device interface {
 
 	Read(b []byte) (int, error)
 
+	// WriteContext sends a binary blob to the device, aborting early and
+	// returning ctx.Err() if ctx is done before the transfer completes.
+	WriteContext(ctx context.Context, b []byte) (int, error)
+
+	// ReadContext retrieves a binary blob from the device, aborting early
+	// and returning ctx.Err() if ctx is done before the transfer completes.
+	ReadContext(ctx context.Context, b []byte) (int, error)
+
 	// Type returns the type of the device (generic or HID)
 	Type() DeviceType
 }